@@ -3,6 +3,8 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -15,11 +17,14 @@ import (
 	"unicode/utf8"
 
 	pb "github.com/VictorTroelsen/Chit-Chat/gRPC"
+	"golang.org/x/crypto/ssh"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 const (
 	defaultAddr     = "localhost:50051"
+	defaultRoom     = "lobby"
 	maxMessageRunes = 128
 	componentClient = "Client"
 	eventSend       = "Send"
@@ -27,6 +32,14 @@ const (
 	eventStart      = "Start"
 	eventShutdown   = "Shutdown"
 	eventValidation = "ValidationError"
+	eventRoom       = "RoomCommand"
+	eventAuth       = "Authenticate"
+	eventAdmin      = "AdminCommand"
+
+	// sessionMetadataKey must match the server's sessionMetadataKey; it
+	// is duplicated rather than shared because client and server are
+	// separate mains with no common internal package.
+	sessionMetadataKey = "chit-chat-session-token"
 )
 
 func setupFileLoggingForClient(username string) (*os.File, error) {
@@ -64,11 +77,163 @@ func (lc *LamportClock) Update(received int64) int64 {
 	return lc.time
 }
 
+// authenticate signs a server-issued nonce with the client's identity
+// key and exchanges it for a fingerprint and a session token, proving
+// to the server that this client holds the matching private key. The
+// returned token must accompany every later RPC (see withSessionToken)
+// for the server to recognize this connection as that fingerprint.
+// Clients started without --identity skip this and connect anonymously.
+func authenticate(ctx context.Context, client pb.ChatServiceClient, username, identityPath string) (fingerprint, token string, err error) {
+	keyBytes, err := os.ReadFile(identityPath)
+	if err != nil {
+		return "", "", fmt.Errorf("read identity: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyBytes)
+	if err != nil {
+		return "", "", fmt.Errorf("parse identity: %w", err)
+	}
+
+	nonceResp, err := client.RequestNonce(ctx, &pb.RequestNonceRequest{User: username})
+	if err != nil {
+		return "", "", fmt.Errorf("request nonce: %w", err)
+	}
+
+	sig, err := signer.Sign(rand.Reader, nonceResp.GetNonce())
+	if err != nil {
+		return "", "", fmt.Errorf("sign nonce: %w", err)
+	}
+
+	resp, err := client.Authenticate(ctx, &pb.AuthenticateRequest{
+		User:      username,
+		Nonce:     nonceResp.GetNonce(),
+		Signature: sig.Blob,
+		PublicKey: signer.PublicKey().Marshal(),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("authenticate: %w", err)
+	}
+	if !resp.GetSuccess() {
+		return "", "", fmt.Errorf("server rejected authentication: %s", resp.GetError())
+	}
+	return resp.GetFingerprint(), resp.GetSessionToken(), nil
+}
+
+// withSessionToken attaches token to ctx as outgoing gRPC metadata, so
+// the server's auth interceptors can resolve it back to the fingerprint
+// Authenticate established. A blank token leaves ctx untouched, which
+// is what anonymous (no --identity) clients want.
+func withSessionToken(ctx context.Context, token string) context.Context {
+	if token == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, sessionMetadataKey, token)
+}
+
 func logEvent(username, event string, lamport int64, details string) {
 	log.Printf("[%s] [User=%s] [EVENT=%s] [Lamport=%d] %s",
 		componentClient, username, event, lamport, details)
 }
 
+// handleSlashCommand parses and executes one of the client's
+// room-management commands (/join, /leave, /rooms, /who) or admin
+// commands (/kick, /mute). Anything else is printed back to the user
+// as unrecognized rather than sent as a chat message.
+func handleSlashCommand(ctx context.Context, client pb.ChatServiceClient, username, authToken string, currentRoom *string, clock *LamportClock, text string) {
+	ctx = withSessionToken(ctx, authToken)
+	fields := strings.Fields(text)
+	cmd := fields[0]
+
+	switch cmd {
+	case "/join":
+		if len(fields) < 2 {
+			fmt.Println("usage: /join <room>")
+			return
+		}
+		room := fields[1]
+		l := clock.Increment()
+		resp, err := client.SendMessage(ctx, &pb.ChatMessage{
+			User:        username,
+			Room:        room,
+			LogicalTime: l,
+			Type:        pb.MessageType_JOIN_ROOM,
+		})
+		if err != nil || !resp.GetSuccess() {
+			logEvent(username, eventRoom, l, fmt.Sprintf("join %s failed: %v %s", room, err, resp.GetError()))
+			fmt.Printf("Could not join %s: %v%s\n", room, err, resp.GetError())
+			return
+		}
+		*currentRoom = room
+		logEvent(username, eventRoom, l, fmt.Sprintf("joined room %s", room))
+		fmt.Printf("Joined room %s\n", room)
+
+	case "/leave":
+		room := *currentRoom
+		if room == defaultRoom {
+			fmt.Println("Already in the default room; nothing to leave.")
+			return
+		}
+		l := clock.Increment()
+		resp, err := client.SendMessage(ctx, &pb.ChatMessage{
+			User:        username,
+			Room:        defaultRoom,
+			LogicalTime: l,
+			Type:        pb.MessageType_JOIN_ROOM,
+		})
+		if err != nil || !resp.GetSuccess() {
+			logEvent(username, eventRoom, l, fmt.Sprintf("leave %s failed: %v %s", room, err, resp.GetError()))
+			fmt.Printf("Could not leave %s: %v%s\n", room, err, resp.GetError())
+			return
+		}
+		*currentRoom = defaultRoom
+		logEvent(username, eventRoom, l, fmt.Sprintf("left room %s, back in %s", room, defaultRoom))
+		fmt.Printf("Left %s, back in %s\n", room, defaultRoom)
+
+	case "/rooms":
+		resp, err := client.ListRooms(ctx, &pb.ListRoomsRequest{})
+		if err != nil {
+			fmt.Println("Could not list rooms:", err)
+			return
+		}
+		for _, r := range resp.GetRooms() {
+			fmt.Printf("  %s (%d)\n", r.GetName(), r.GetParticipantCount())
+		}
+
+	case "/who":
+		resp, err := client.ListParticipants(ctx, &pb.ListParticipantsRequest{Room: *currentRoom})
+		if err != nil {
+			fmt.Println("Could not list participants:", err)
+			return
+		}
+		fmt.Println(strings.Join(resp.GetUsers(), ", "))
+
+	case "/kick", "/mute":
+		if len(fields) < 2 {
+			fmt.Printf("usage: %s <user>\n", cmd)
+			return
+		}
+		target := fields[1]
+		action := pb.AdminActionType_KICK
+		if cmd == "/mute" {
+			action = pb.AdminActionType_MUTE
+		}
+		resp, err := client.AdminAction(ctx, &pb.AdminActionRequest{
+			AdminUser:  username,
+			TargetUser: target,
+			Action:     action,
+		})
+		if err != nil || !resp.GetSuccess() {
+			logEvent(username, eventAdmin, clock.time, fmt.Sprintf("%s %s failed: %v %s", cmd, target, err, resp.GetError()))
+			fmt.Printf("%s failed: %v%s\n", cmd, err, resp.GetError())
+			return
+		}
+		logEvent(username, eventAdmin, clock.time, fmt.Sprintf("%s %s", cmd, target))
+		fmt.Printf("%s %s: ok\n", cmd, target)
+
+	default:
+		fmt.Println("Unknown command:", cmd)
+	}
+}
+
 func validateUserMessage(msg string) error {
 	if utf8.RuneCountInString(msg) > maxMessageRunes {
 		return fmt.Errorf("message exceeds %d characters", maxMessageRunes)
@@ -77,14 +242,18 @@ func validateUserMessage(msg string) error {
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("usage: client <username> [serverAddr]")
+	identityPath := flag.String("identity", "", "path to an SSH-format private key used to authenticate this client")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		fmt.Println("usage: client [--identity=<path>] <username> [serverAddr]")
 		os.Exit(1)
 	}
-	username := strings.TrimSpace(os.Args[1])
+	username := strings.TrimSpace(args[0])
 	serverAddr := defaultAddr
-	if len(os.Args) >= 3 {
-		serverAddr = os.Args[2]
+	if len(args) >= 2 {
+		serverAddr = args[1]
 	}
 
 	// console logging
@@ -107,7 +276,18 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	stream, err := client.StreamMessages(ctx, &pb.StreamRequest{Room: username})
+	var authToken string
+	if *identityPath != "" {
+		fingerprint, token, err := authenticate(ctx, client, username, *identityPath)
+		if err != nil {
+			log.Fatalf("authentication error: %v", err)
+		}
+		authToken = token
+		logEvent(username, eventAuth, 0, fmt.Sprintf("authenticated as %s", fingerprint))
+	}
+
+	currentRoom := defaultRoom
+	stream, err := client.StreamMessages(withSessionToken(ctx, authToken), &pb.StreamRequest{User: username, Room: currentRoom})
 	if err != nil {
 		log.Fatalf("stream error: %v", err)
 	}
@@ -158,6 +338,12 @@ func main() {
 			if text == "" {
 				continue
 			}
+
+			if strings.HasPrefix(text, "/") {
+				handleSlashCommand(ctx, client, username, authToken, &currentRoom, clock, text)
+				continue
+			}
+
 			if err := validateUserMessage(text); err != nil {
 				logEvent(username, eventValidation, clock.time, err.Error())
 				fmt.Println("Error:", err)
@@ -167,8 +353,9 @@ func main() {
 			l := clock.Increment()
 
 			// Send chat message
-			resp, err := client.SendMessage(context.Background(), &pb.ChatMessage{
+			resp, err := client.SendMessage(withSessionToken(context.Background(), authToken), &pb.ChatMessage{
 				User:        username,
+				Room:        currentRoom,
 				Message:     text,
 				LogicalTime: l,
 				Type:        pb.MessageType_CHAT,
@@ -183,7 +370,7 @@ func main() {
 				fmt.Println("Server rejected:", resp.GetError())
 				continue
 			}
-			logEvent(username, eventSend, l, fmt.Sprintf("text=%q", text))
+			logEvent(username, eventSend, l, fmt.Sprintf("room=%s text=%q", currentRoom, text))
 		}
 	}
 }
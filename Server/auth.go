@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	componentAuth    = "Auth"
+	eventAuth        = "Authenticate"
+	eventAdmin       = "AdminAction"
+	nonceSize        = 32
+	sessionTokenSize = 32
+	sessionTTL       = time.Hour
+)
+
+// session is what Authenticate hands back to a caller that proved it
+// holds a fingerprint's private key. It is looked up by session token,
+// never by the self-asserted username, so a client cannot gain another
+// user's privileges by simply claiming their name.
+type session struct {
+	fingerprint string
+	admin       bool
+	expiresAt   time.Time
+}
+
+// authState holds everything needed for optional pubkey authentication
+// and admin moderation. An empty allowlist means authentication is not
+// required and clients connect anonymously, matching today's behavior.
+type authState struct {
+	mu sync.Mutex
+
+	adminFingerprints map[string]bool
+	allowlist         map[string]bool
+
+	nonces   map[string][]byte             // user -> pending nonce
+	sessions map[string]*session           // session token -> session
+	muted    map[string]bool               // user -> muted
+	cancels  map[string]context.CancelFunc // clientID -> cancel for their active stream
+}
+
+func newAuthState(adminFingerprints, allowlist map[string]bool) *authState {
+	return &authState{
+		adminFingerprints: adminFingerprints,
+		allowlist:         allowlist,
+		nonces:            make(map[string][]byte),
+		sessions:          make(map[string]*session),
+		muted:             make(map[string]bool),
+		cancels:           make(map[string]context.CancelFunc),
+	}
+}
+
+// loadFingerprintFile reads SHA256 pubkey fingerprints, one per line,
+// from either bare-fingerprint lines ("SHA256:xxxx") or full
+// `ssh-keygen -lf` lines ("256 SHA256:xxxx user@host (ED25519)"),
+// extracting the SHA256 token from the latter. Blank lines and lines
+// starting with '#' are ignored.
+func loadFingerprintFile(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	fingerprints := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fingerprints[fingerprintToken(line)] = true
+	}
+	return fingerprints, scanner.Err()
+}
+
+// fingerprintToken extracts the "SHA256:xxxx" token from a line, so
+// both bare-fingerprint files and raw `ssh-keygen -lf` output work.
+func fingerprintToken(line string) string {
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, "SHA256:") {
+			return field
+		}
+	}
+	return line
+}
+
+// newSession mints an opaque, unguessable session token bound to
+// fingerprint and records it with a fixed TTL. The token, not the
+// caller-supplied username, is what later RPCs must present to prove
+// they are still the party that authenticated.
+func (a *authState) newSession(fingerprint string) (string, error) {
+	buf := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate session token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	a.mu.Lock()
+	a.sessions[token] = &session{
+		fingerprint: fingerprint,
+		admin:       a.adminFingerprints[fingerprint],
+		expiresAt:   time.Now().Add(sessionTTL),
+	}
+	a.mu.Unlock()
+	return token, nil
+}
+
+// lookupSession returns the session for token, or nil if token is
+// empty, unknown, or expired. An expired session is evicted as a side
+// effect.
+func (a *authState) lookupSession(token string) *session {
+	if token == "" {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, ok := a.sessions[token]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(sess.expiresAt) {
+		delete(a.sessions, token)
+		return nil
+	}
+	return sess
+}
+
+func (s *chatServer) registerCancel(clientID string, cancel context.CancelFunc) {
+	s.auth.mu.Lock()
+	s.auth.cancels[clientID] = cancel
+	s.auth.mu.Unlock()
+}
+
+func (s *chatServer) unregisterCancel(clientID string) {
+	s.auth.mu.Lock()
+	delete(s.auth.cancels, clientID)
+	s.auth.mu.Unlock()
+}
+
+func (s *chatServer) isMuted(user string) bool {
+	s.auth.mu.Lock()
+	defer s.auth.mu.Unlock()
+	return s.auth.muted[user]
+}
+
+func (s *chatServer) setMuted(user string, muted bool) {
+	s.auth.mu.Lock()
+	s.auth.muted[user] = muted
+	s.auth.mu.Unlock()
+}
+
+// kickClient cancels the stream context of a connected client, if any,
+// which unblocks StreamMessages and disconnects them.
+func (s *chatServer) kickClient(user string) bool {
+	s.auth.mu.Lock()
+	cancel, ok := s.auth.cancels[user]
+	s.auth.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *chatServer) RequestNonce(ctx context.Context, req *pb.RequestNonceRequest) (*pb.RequestNonceResponse, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	s.auth.mu.Lock()
+	s.auth.nonces[req.GetUser()] = nonce
+	s.auth.mu.Unlock()
+
+	return &pb.RequestNonceResponse{Nonce: nonce}, nil
+}
+
+// Authenticate verifies that the caller holds the private key matching
+// the public key it presents, by checking its signature over the nonce
+// previously issued by RequestNonce. On success it mints a session
+// token bound to the caller's SHA256 pubkey fingerprint; that token,
+// not the self-asserted username, is what authUnaryInterceptor and
+// authStreamInterceptor require on every later RPC when an allowlist
+// is configured.
+func (s *chatServer) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	s.auth.mu.Lock()
+	nonce, ok := s.auth.nonces[req.GetUser()]
+	delete(s.auth.nonces, req.GetUser())
+	s.auth.mu.Unlock()
+	if !ok {
+		return &pb.AuthenticateResponse{Success: false, Error: "no pending nonce; call RequestNonce first"}, nil
+	}
+
+	pubKey, err := ssh.ParsePublicKey(req.GetPublicKey())
+	if err != nil {
+		return &pb.AuthenticateResponse{Success: false, Error: fmt.Sprintf("invalid public key: %v", err)}, nil
+	}
+
+	sig := &ssh.Signature{Format: pubKey.Type(), Blob: req.GetSignature()}
+	if err := pubKey.Verify(nonce, sig); err != nil {
+		return &pb.AuthenticateResponse{Success: false, Error: "signature verification failed"}, nil
+	}
+
+	fingerprint := ssh.FingerprintSHA256(pubKey)
+	if len(s.auth.allowlist) > 0 && !s.auth.allowlist[fingerprint] {
+		logEvent(eventAuth, req.GetUser(), 0, fmt.Sprintf("fingerprint %s rejected: not on allowlist", fingerprint))
+		return &pb.AuthenticateResponse{Success: false, Error: "fingerprint not in allowlist"}, nil
+	}
+
+	token, err := s.auth.newSession(fingerprint)
+	if err != nil {
+		return &pb.AuthenticateResponse{Success: false, Error: err.Error()}, nil
+	}
+
+	logEvent(eventAuth, req.GetUser(), 0, fmt.Sprintf("authenticated as fingerprint %s", fingerprint))
+	return &pb.AuthenticateResponse{Success: true, Fingerprint: fingerprint, SessionToken: token}, nil
+}
+
+// AdminAction lets an authenticated admin kick or mute a user. The
+// caller's admin privilege comes from the session their RPC was
+// authenticated under (see authUnaryInterceptor), not from the
+// self-asserted AdminUser field, which exists only for logging.
+func (s *chatServer) AdminAction(ctx context.Context, req *pb.AdminActionRequest) (*pb.AdminActionResponse, error) {
+	sess, ok := sessionFromContext(ctx)
+	if !ok || !sess.admin {
+		return &pb.AdminActionResponse{Success: false, Error: "not an admin"}, nil
+	}
+
+	switch req.GetAction() {
+	case pb.AdminActionType_KICK:
+		s.kickClient(req.GetTargetUser())
+	case pb.AdminActionType_MUTE:
+		s.setMuted(req.GetTargetUser(), true)
+	case pb.AdminActionType_UNMUTE:
+		s.setMuted(req.GetTargetUser(), false)
+	default:
+		return &pb.AdminActionResponse{Success: false, Error: "unknown admin action"}, nil
+	}
+
+	logEvent(eventAdmin, req.GetAdminUser(), 0, fmt.Sprintf("%s -> %s", req.GetAction().String(), req.GetTargetUser()))
+	return &pb.AdminActionResponse{Success: true}, nil
+}
+
+// sessionMetadataKey is the gRPC metadata header a client presents its
+// session token under on every RPC after Authenticate.
+const sessionMetadataKey = "chit-chat-session-token"
+
+type sessionCtxKey struct{}
+
+func withSession(ctx context.Context, sess *session) context.Context {
+	return context.WithValue(ctx, sessionCtxKey{}, sess)
+}
+
+func sessionFromContext(ctx context.Context) (*session, bool) {
+	sess, ok := ctx.Value(sessionCtxKey{}).(*session)
+	return sess, ok
+}
+
+// noAuthMethods are the RPCs a caller must be able to reach before it
+// has a session token: the nonce/signature handshake that produces one.
+var noAuthMethods = map[string]bool{
+	"/chat.ChatService/RequestNonce": true,
+	"/chat.ChatService/Authenticate": true,
+}
+
+// authenticate resolves the session token attached to ctx (if any) and
+// enforces the allowlist for every RPC except the handshake itself.
+// With no allowlist configured this only attaches the session (so
+// AdminAction can still recognize admins) and never rejects, matching
+// today's anonymous-by-default behavior. On success it returns ctx
+// with the resolved session attached so handlers never need to trust a
+// self-asserted username for authorization decisions.
+func (s *chatServer) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if noAuthMethods[fullMethod] {
+		return ctx, nil
+	}
+
+	sess := s.auth.lookupSession(tokenFromContext(ctx))
+
+	if len(s.auth.allowlist) > 0 {
+		if sess == nil {
+			return ctx, fmt.Errorf("authentication required: no valid session token presented")
+		}
+		if !s.auth.allowlist[sess.fingerprint] {
+			return ctx, fmt.Errorf("fingerprint %s is not on the allowlist", sess.fingerprint)
+		}
+	}
+
+	if sess != nil {
+		ctx = withSession(ctx, sess)
+	}
+	return ctx, nil
+}
+
+func tokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if vals := md.Get(sessionMetadataKey); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// authUnaryInterceptor gates every unary RPC (e.g. SendMessage,
+// AdminAction) behind the same allowlist check StreamMessages gets via
+// authStreamInterceptor, so an unauthenticated caller cannot bypass the
+// allowlist by never opening a stream.
+func (s *chatServer) authUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(ctx, req)
+}
+
+// authStreamServerStream wraps a grpc.ServerStream so its Context()
+// returns the context carrying the resolved session, rather than the
+// stream's raw incoming context.
+type authStreamServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *authStreamServerStream) Context() context.Context { return w.ctx }
+
+func (s *chatServer) authStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(srv, &authStreamServerStream{ServerStream: ss, ctx: ctx})
+}
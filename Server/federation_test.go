@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+	"go.etcd.io/etcd/server/v3/embed"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startEmbeddedEtcd brings up a single-node embedded etcd cluster for
+// the duration of the test and returns its client endpoint.
+func startEmbeddedEtcd(t *testing.T) string {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	clientURL, err := url.Parse("http://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("parse client url: %v", err)
+	}
+	peerURL, err := url.Parse("http://127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("parse peer url: %v", err)
+	}
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.InitialClusterToken = "chit-chat-test"
+	cfg.LogLevel = "error"
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("start embedded etcd: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("embedded etcd did not become ready in time")
+	}
+	return e.Clients[0].Addr().String()
+}
+
+// startFederatedNode wires up a chatServer with federation enabled
+// against etcdEndpoint and serves it over an in-process bufconn
+// listener, returning a client connected to it.
+func startFederatedNode(t *testing.T, etcdEndpoint, nodeID string) pb.ChatServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	srv := newChatServer(context.Background(), t.TempDir(), nil, nil)
+	if err := srv.enableFederation(context.Background(), []string{etcdEndpoint}, nodeID); err != nil {
+		t.Fatalf("enable federation for %s: %v", nodeID, err)
+	}
+	pb.RegisterChatServiceServer(grpcServer, srv)
+
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial %s: %v", nodeID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewChatServiceClient(conn)
+}
+
+// TestFederationBroadcastReachesPeerNode checks that a message sent to
+// node A is fanned out through etcd and delivered to a client streaming
+// from node B, with its Lamport time advanced past what B had already
+// seen.
+func TestFederationBroadcastReachesPeerNode(t *testing.T) {
+	etcdEndpoint := startEmbeddedEtcd(t)
+
+	nodeA := startFederatedNode(t, etcdEndpoint, "node-a")
+	nodeB := startFederatedNode(t, etcdEndpoint, "node-b")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	streamB, err := nodeB.StreamMessages(ctx, &pb.StreamRequest{User: "bob", Room: "lobby"})
+	if err != nil {
+		t.Fatalf("stream from node B: %v", err)
+	}
+
+	joinMsg, err := streamB.Recv()
+	if err != nil {
+		t.Fatalf("recv join notice: %v", err)
+	}
+
+	resp, err := nodeA.SendMessage(ctx, &pb.ChatMessage{
+		User:    "alice",
+		Room:    "lobby",
+		Message: "hi from A",
+		Type:    pb.MessageType_CHAT,
+	})
+	if err != nil {
+		t.Fatalf("send from node A: %v", err)
+	}
+	if !resp.GetSuccess() {
+		t.Fatalf("node A rejected the message: %s", resp.GetError())
+	}
+
+	msg, err := streamB.Recv()
+	if err != nil {
+		t.Fatalf("recv fanned-out message on node B: %v", err)
+	}
+	if msg.GetMessage() != "hi from A" || msg.GetUser() != "alice" {
+		t.Fatalf("unexpected message delivered to node B: %+v", msg)
+	}
+	if msg.GetLogicalTime() <= joinMsg.GetLogicalTime() {
+		t.Fatalf("expected lamport time to advance past join (%d), got %d", joinMsg.GetLogicalTime(), msg.GetLogicalTime())
+	}
+}
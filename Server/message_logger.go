@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+)
+
+const (
+	componentLogger = "MessageLogger"
+	logWriterBuffer = 256
+	defaultLogDir   = "logs/messages"
+	logDateLayout   = "20060102"
+)
+
+// validRoomName matches the room names this package will ever use to
+// build an on-disk path or glob pattern. Room names come from
+// client-controlled fields (StreamRequest.Room, ChatMessage.Room) with
+// no upstream validation, so without this a room like "../../../tmp/evil"
+// would let a client read or write arbitrary files outside logDir.
+var validRoomName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// loggedMessage is the on-disk representation of one archived message:
+// one JSON object per line, newest appended last.
+type loggedMessage struct {
+	Room        string `json:"room"`
+	User        string `json:"user"`
+	Message     string `json:"message"`
+	Type        string `json:"type"`
+	LogicalTime int64  `json:"logical_time"`
+	WallClock   string `json:"wall_clock"`
+}
+
+// roomLog owns the append-only file for a single room and the
+// goroutine that serializes writes to it so broadcast never blocks on
+// disk I/O.
+type roomLog struct {
+	room    string
+	logDir  string
+	writeCh chan *pb.ChatMessage
+	done    chan struct{}
+
+	mu   sync.Mutex
+	file *os.File
+	day  string
+}
+
+// messageLogger writes every broadcast message to a per-room,
+// append-only log under logDir so reconnecting clients can replay
+// history the in-memory clients map can no longer provide after a
+// restart.
+type messageLogger struct {
+	logDir string
+
+	mu    sync.Mutex
+	rooms map[string]*roomLog
+}
+
+func newMessageLogger(logDir string) *messageLogger {
+	if logDir == "" {
+		logDir = defaultLogDir
+	}
+	return &messageLogger{
+		logDir: logDir,
+		rooms:  make(map[string]*roomLog),
+	}
+}
+
+func (ml *messageLogger) roomLogFor(room string) *roomLog {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if rl, ok := ml.rooms[room]; ok {
+		return rl
+	}
+
+	rl := &roomLog{
+		room:    room,
+		logDir:  ml.logDir,
+		writeCh: make(chan *pb.ChatMessage, logWriterBuffer),
+		done:    make(chan struct{}),
+	}
+	ml.rooms[room] = rl
+	go rl.run()
+	return rl
+}
+
+// Log enqueues msg for the given room's writer goroutine. It never
+// blocks the broadcast loop: the channel is large enough to absorb
+// bursts, and a full channel drops the write rather than stalling.
+func (ml *messageLogger) Log(room string, msg *pb.ChatMessage) {
+	if !validRoomName.MatchString(room) {
+		log.Printf("[%s] refusing to log to invalid room name %q", componentLogger, room)
+		return
+	}
+	rl := ml.roomLogFor(room)
+	select {
+	case rl.writeCh <- msg:
+	default:
+		log.Printf("[%s] [Room=%s] write buffer full, dropping log entry for lamport=%d", componentLogger, room, msg.GetLogicalTime())
+	}
+}
+
+// Replay streams archived messages for room with LogicalTime > since,
+// in order, to send. It is used to catch a reconnecting client up
+// before live traffic resumes.
+func (ml *messageLogger) Replay(room string, since int64, send func(*pb.ChatMessage) error) error {
+	if !validRoomName.MatchString(room) {
+		return fmt.Errorf("invalid room name %q", room)
+	}
+
+	pattern := filepath.Join(ml.logDir, fmt.Sprintf("%s-*.log", room))
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("glob room log: %w", err)
+	}
+
+	for _, path := range matches {
+		// The glob also matches logs of rooms named "<room>-...";
+		// keep only files whose suffix is exactly this room's date.
+		day := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), room+"-"), ".log")
+		if _, err := time.Parse(logDateLayout, day); err != nil {
+			continue
+		}
+		if err := replayFile(path, since, send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayFile(path string, since int64, send func(*pb.ChatMessage) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var lm loggedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &lm); err != nil {
+			log.Printf("[%s] skipping malformed log line in %s: %v", componentLogger, path, err)
+			continue
+		}
+		if lm.LogicalTime <= since {
+			continue
+		}
+		if err := send(&pb.ChatMessage{
+			Room:        lm.Room,
+			User:        lm.User,
+			Message:     lm.Message,
+			LogicalTime: lm.LogicalTime,
+			Type:        pb.MessageType(pb.MessageType_value[lm.Type]),
+		}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// run is the dedicated writer goroutine for one room; it owns the
+// room's open file handle and rotates it by UTC day.
+func (rl *roomLog) run() {
+	defer close(rl.done)
+	for msg := range rl.writeCh {
+		if err := rl.append(msg); err != nil {
+			log.Printf("[%s] [Room=%s] write failed: %v", componentLogger, rl.room, err)
+		}
+	}
+	if rl.file != nil {
+		rl.file.Close()
+	}
+}
+
+// Close drains and flushes every room's pending writes, blocking until
+// each writer goroutine has exited and closed its file. It is called
+// during server shutdown so no buffered log entry is lost.
+func (ml *messageLogger) Close() {
+	ml.mu.Lock()
+	rooms := make([]*roomLog, 0, len(ml.rooms))
+	for _, rl := range ml.rooms {
+		rooms = append(rooms, rl)
+	}
+	ml.mu.Unlock()
+
+	for _, rl := range rooms {
+		close(rl.writeCh)
+		<-rl.done
+	}
+}
+
+func (rl *roomLog) append(msg *pb.ChatMessage) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	day := time.Now().UTC().Format(logDateLayout)
+	if rl.file == nil || rl.day != day {
+		if rl.file != nil {
+			rl.file.Close()
+		}
+		if err := os.MkdirAll(rl.logDir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(rl.logDir, fmt.Sprintf("%s-%s.log", rl.room, day))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		rl.file = f
+		rl.day = day
+	}
+
+	lm := loggedMessage{
+		Room:        rl.room,
+		User:        msg.GetUser(),
+		Message:     msg.GetMessage(),
+		Type:        msg.GetType().String(),
+		LogicalTime: msg.GetLogicalTime(),
+		WallClock:   time.Now().UTC().Format(time.RFC3339Nano),
+	}
+	line, err := json.Marshal(lm)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	_, err = rl.file.Write(line)
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestLeaveRoomThenJoinRoomRecovers exercises the raw LEAVE_ROOM RPC
+// directly (as opposed to the client's /leave command, which routes
+// through JOIN_ROOM instead): a client that leaves its room via
+// LEAVE_ROOM and then joins a different one must succeed, not get
+// stuck with "client ... is not streaming" because clientRooms still
+// points at the room it already left.
+func TestLeaveRoomThenJoinRoomRecovers(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+
+	srv := newChatServer(context.Background(), t.TempDir(), nil, nil)
+	pb.RegisterChatServiceServer(grpcServer, srv)
+
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewChatServiceClient(conn)
+	stream, err := client.StreamMessages(context.Background(), &pb.StreamRequest{User: "dave", Room: "lobby"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv join notice: %v", err)
+	}
+
+	resp, err := client.SendMessage(context.Background(), &pb.ChatMessage{
+		User: "dave",
+		Room: "lobby",
+		Type: pb.MessageType_LEAVE_ROOM,
+	})
+	if err != nil || !resp.GetSuccess() {
+		t.Fatalf("leave lobby: err=%v resp=%+v", err, resp)
+	}
+
+	resp, err = client.SendMessage(context.Background(), &pb.ChatMessage{
+		User: "dave",
+		Room: "general",
+		Type: pb.MessageType_JOIN_ROOM,
+	})
+	if err != nil || !resp.GetSuccess() {
+		t.Fatalf("join general after leaving lobby: err=%v resp=%+v", err, resp)
+	}
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode/utf8"
 
 	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
 )
 
@@ -24,6 +31,15 @@ const (
 	eventJoin        = "Join"
 	eventLeave       = "Leave"
 	eventDeliveryErr = "DeliveryError"
+	eventPeerUp      = "PeerUp"
+	eventPeerDown    = "PeerDown"
+	eventPeerRecv    = "PeerReceive"
+	eventShutdown    = "Shutdown"
+
+	etcdNodePrefix      = "/chit-chat/nodes/"
+	etcdBroadcastPrefix = "/chit-chat/broadcast/"
+	etcdNodeLeaseTTL    = 10 // seconds
+	etcdOriginTTL       = 2 * time.Minute
 )
 
 // Logging to file setup
@@ -66,17 +82,207 @@ func (lc *LamportClock) Update(received int64) int64 {
 	return lc.time
 }
 
-type chatServer struct {
-	pb.UnimplementedChatServiceServer
+// broadcastEnvelope is what gets published to etcd so peer nodes can
+// replay a message to their own locally connected clients. NodeID +
+// Seq together form the dedup key peers use to avoid delivering
+// something they already delivered (see seenOrigin).
+type broadcastEnvelope struct {
+	NodeID  string          `json:"node_id"`
+	Seq     int64           `json:"seq"`
+	Message *pb.ChatMessage `json:"message"`
+}
+
+// roomState holds the clients currently subscribed to a single room
+// and that room's own Lamport clock, so activity in one room cannot
+// artificially advance the ordering of an unrelated room.
+type roomState struct {
 	mu      sync.Mutex
 	clients map[string]pb.ChatService_StreamMessagesServer
 	clock   LamportClock
 }
 
-func newChatServer() *chatServer {
+type chatServer struct {
+	pb.UnimplementedChatServiceServer
+
+	ctx context.Context
+
+	roomsMu       sync.Mutex
+	rooms         map[string]*roomState
+	clientRooms   map[string]string                              // clientID -> room they are currently subscribed to ("" if in no room)
+	clientStreams map[string]pb.ChatService_StreamMessagesServer // clientID -> their stream, independent of room membership
+
+	// federation
+	nodeID     string
+	etcdClient *clientv3.Client
+	leaseID    clientv3.LeaseID
+	seq        int64
+
+	originsMu sync.Mutex
+	origins   map[string]time.Time // "<nodeID>:<seq>" -> first-seen
+
+	logger *messageLogger
+	auth   *authState
+}
+
+// newChatServer takes the parent context the server should shut down
+// with: StreamMessages unblocks as soon as ctx is cancelled, so a
+// signal handler can drain every in-flight stream without tearing down
+// the gRPC connections out from under them.
+func newChatServer(ctx context.Context, logDir string, adminFingerprints, allowlist map[string]bool) *chatServer {
 	return &chatServer{
-		clients: make(map[string]pb.ChatService_StreamMessagesServer),
+		ctx:           ctx,
+		rooms:         make(map[string]*roomState),
+		clientRooms:   make(map[string]string),
+		clientStreams: make(map[string]pb.ChatService_StreamMessagesServer),
+		origins:       make(map[string]time.Time),
+		logger:        newMessageLogger(logDir),
+		auth:          newAuthState(adminFingerprints, allowlist),
+	}
+}
+
+// roomFor returns the roomState for name, creating it if this is the
+// first time anyone has referenced it.
+func (s *chatServer) roomFor(name string) *roomState {
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+
+	rs, ok := s.rooms[name]
+	if !ok {
+		rs = &roomState{clients: make(map[string]pb.ChatService_StreamMessagesServer)}
+		s.rooms[name] = rs
+	}
+	return rs
+}
+
+// enableFederation wires the server up to etcd for multi-node
+// broadcast fan-out and peer discovery. Called from main only when
+// --etcd-endpoints is set; a server with no etcd client behaves exactly
+// like today's single-node server.
+func (s *chatServer) enableFederation(ctx context.Context, endpoints []string, nodeID string) error {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("connect to etcd: %w", err)
+	}
+
+	s.etcdClient = cli
+	s.nodeID = nodeID
+
+	lease, err := cli.Grant(ctx, etcdNodeLeaseTTL)
+	if err != nil {
+		return fmt.Errorf("grant lease: %w", err)
 	}
+	s.leaseID = lease.ID
+
+	if _, err := cli.Put(ctx, etcdNodePrefix+nodeID, nodeID, clientv3.WithLease(lease.ID)); err != nil {
+		return fmt.Errorf("register node: %w", err)
+	}
+
+	keepAlive, err := cli.KeepAlive(ctx, lease.ID)
+	if err != nil {
+		return fmt.Errorf("keepalive: %w", err)
+	}
+
+	go s.consumeKeepAlive(ctx, keepAlive)
+	go s.watchPeers(ctx)
+	go s.watchBroadcasts(ctx)
+
+	log.Printf("[%s] federation enabled node=%s endpoints=%s", componentServer, nodeID, strings.Join(endpoints, ","))
+	return nil
+}
+
+func (s *chatServer) consumeKeepAlive(ctx context.Context, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *chatServer) watchPeers(ctx context.Context) {
+	for resp := range s.etcdClient.Watch(ctx, etcdNodePrefix, clientv3.WithPrefix()) {
+		for _, ev := range resp.Events {
+			peerID := strings.TrimPrefix(string(ev.Kv.Key), etcdNodePrefix)
+			if peerID == s.nodeID {
+				continue
+			}
+			switch ev.Type {
+			case clientv3.EventTypePut:
+				logEvent(eventPeerUp, peerID, 0, "peer node discovered")
+			case clientv3.EventTypeDelete:
+				logEvent(eventPeerDown, peerID, 0, "peer node lease expired")
+			}
+		}
+	}
+}
+
+// watchBroadcasts receives messages peer nodes have published and
+// delivers them to this node's locally connected clients, merging the
+// remote Lamport time into our own clock.
+func (s *chatServer) watchBroadcasts(ctx context.Context) {
+	for resp := range s.etcdClient.Watch(ctx, etcdBroadcastPrefix, clientv3.WithPrefix()) {
+		for _, ev := range resp.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			var env broadcastEnvelope
+			if err := json.Unmarshal(ev.Kv.Value, &env); err != nil {
+				log.Printf("[%s] discarding malformed peer broadcast: %v", componentServer, err)
+				continue
+			}
+			if env.NodeID == s.nodeID {
+				continue // our own publish, already delivered locally
+			}
+			if s.seenOrigin(env.NodeID, env.Seq) {
+				continue
+			}
+
+			rs := s.roomFor(env.Message.GetRoom())
+			lTime := rs.clock.Update(env.Message.GetLogicalTime())
+			env.Message.LogicalTime = lTime
+
+			s.deliverToRoom(env.Message.GetRoom(), env.Message)
+			logEvent(eventPeerRecv, env.Message.GetUser(), lTime, fmt.Sprintf("origin=%s", env.NodeID))
+		}
+	}
+}
+
+// seenOrigin reports whether (nodeID, seq) has already been delivered,
+// recording it if not. This is the duplicate-suppression guard: it
+// keeps a peer message that reaches this node's watcher more than once
+// from being delivered to local clients twice (watchBroadcasts only
+// delivers locally and never republishes to etcd). It keys on
+// the envelope's per-node Seq rather than the message's Lamport time:
+// Lamport time is now scoped per-room, so two different rooms on the
+// same node can legitimately produce the same logical time, which
+// would otherwise collide here and silently drop the second room's
+// message as a false-positive duplicate. Seq is a single monotonic
+// counter per node regardless of room, so it can't collide that way.
+func (s *chatServer) seenOrigin(nodeID string, seq int64) bool {
+	key := fmt.Sprintf("%s:%d", nodeID, seq)
+
+	s.originsMu.Lock()
+	defer s.originsMu.Unlock()
+
+	now := time.Now()
+	for k, seenAt := range s.origins {
+		if now.Sub(seenAt) > etcdOriginTTL {
+			delete(s.origins, k)
+		}
+	}
+
+	if _, ok := s.origins[key]; ok {
+		return true
+	}
+	s.origins[key] = now
+	return false
 }
 
 func logEvent(event, clientID string, lamport int64, details string) {
@@ -84,22 +290,60 @@ func logEvent(event, clientID string, lamport int64, details string) {
 		componentServer, event, clientID, lamport, details)
 }
 
-func (s *chatServer) broadcast(msg *pb.ChatMessage) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// deliverToRoom sends msg to every client subscribed to room on this
+// node. It does not publish to etcd, so it is safe to call for
+// messages that originated on a peer node.
+func (s *chatServer) deliverToRoom(room string, msg *pb.ChatMessage) {
+	rs := s.roomFor(room)
 
-	for id, stream := range s.clients {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for id, stream := range rs.clients {
 		if err := stream.Send(msg); err != nil {
 			logEvent(eventDeliveryErr, id, msg.LogicalTime, fmt.Sprintf("send failed: %v (removing client)", err))
-			delete(s.clients, id)
+			delete(rs.clients, id)
 		}
 	}
 }
 
+// broadcast delivers msg to clients subscribed to msg.Room on this
+// node and, when federation is enabled, fans it out to peer nodes so
+// their subscribers to that room receive it too.
+func (s *chatServer) broadcast(msg *pb.ChatMessage) {
+	s.deliverToRoom(msg.GetRoom(), msg)
+	s.logger.Log(msg.GetRoom(), msg)
+
+	if s.etcdClient == nil {
+		return
+	}
+
+	seq := atomic.AddInt64(&s.seq, 1)
+	env := broadcastEnvelope{NodeID: s.nodeID, Seq: seq, Message: msg}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("[%s] failed to encode peer broadcast: %v", componentServer, err)
+		return
+	}
+
+	// Reuse the node's own keep-alive'd lease rather than minting a
+	// fresh one per message: at real chat volume that would blow
+	// through etcd's lease limits almost immediately. The broadcast key
+	// expires along with the node (etcdNodeLeaseTTL), which is exactly
+	// the lifetime we want for a peer fan-out record.
+	key := fmt.Sprintf("%s%s-%d", etcdBroadcastPrefix, s.nodeID, seq)
+	if _, err := s.etcdClient.Put(context.Background(), key, string(payload), clientv3.WithLease(s.leaseID)); err != nil {
+		log.Printf("[%s] failed to publish peer broadcast: %v", componentServer, err)
+	}
+}
+
 func (s *chatServer) validateMessage(m *pb.ChatMessage) error {
 	if utf8.RuneCountInString(m.Message) > maxMessageRunes {
 		return fmt.Errorf("message exceeds %d characters", maxMessageRunes)
 	}
+	if s.isMuted(m.GetUser()) {
+		return fmt.Errorf("%s is muted", m.GetUser())
+	}
 	return nil
 }
 
@@ -108,51 +352,256 @@ func (s *chatServer) SendMessage(ctx context.Context, msg *pb.ChatMessage) (*pb.
 		return &pb.ChatResponse{Success: false, Error: err.Error()}, nil
 	}
 
-	lTime := s.clock.Update(msg.LogicalTime)
+	switch msg.GetType() {
+	case pb.MessageType_JOIN_ROOM:
+		if err := s.moveClient(msg.GetUser(), msg.GetRoom()); err != nil {
+			return &pb.ChatResponse{Success: false, Error: err.Error()}, nil
+		}
+	case pb.MessageType_LEAVE_ROOM:
+		s.clearClientRoom(msg.GetUser(), msg.GetRoom())
+	}
+
+	rs := s.roomFor(msg.GetRoom())
+	lTime := rs.clock.Update(msg.LogicalTime)
 	msg.LogicalTime = lTime
 
 	s.broadcast(msg)
-	logEvent(eventBroadcast, msg.User, lTime, fmt.Sprintf("type=%s text=%q", msg.Type.String(), msg.Message))
+	logEvent(eventBroadcast, msg.User, lTime, fmt.Sprintf("room=%s type=%s text=%q", msg.Room, msg.Type.String(), msg.Message))
 	return &pb.ChatResponse{Success: true}, nil
 }
 
+// moveClient relocates clientID's stream from whatever room it is
+// currently subscribed to (if any) into newRoom, without the
+// underlying gRPC stream ever being torn down. clientRooms[clientID]
+// may be "" if the client previously left its room via LEAVE_ROOM
+// without joining a new one; moveClient still finds its stream via
+// clientStreams, which is keyed independently of room membership.
+func (s *chatServer) moveClient(clientID, newRoom string) error {
+	s.roomsMu.Lock()
+	oldRoom, ok := s.clientRooms[clientID]
+	stream, streamOK := s.clientStreams[clientID]
+	s.roomsMu.Unlock()
+	if !ok || !streamOK {
+		return fmt.Errorf("client %s is not streaming", clientID)
+	}
+	if oldRoom == newRoom {
+		return nil
+	}
+
+	if oldRoom != "" {
+		s.removeClientFromRoom(clientID, oldRoom)
+	}
+
+	newRS := s.roomFor(newRoom)
+	newRS.mu.Lock()
+	newRS.clients[clientID] = stream
+	newRS.mu.Unlock()
+
+	s.roomsMu.Lock()
+	s.clientRooms[clientID] = newRoom
+	s.roomsMu.Unlock()
+
+	return nil
+}
+
+// removeClientFromRoom drops clientID from room's client list. It does
+// not touch clientRooms/clientStreams bookkeeping; callers that are
+// moving or fully disconnecting the client update those themselves.
+func (s *chatServer) removeClientFromRoom(clientID, room string) {
+	rs := s.roomFor(room)
+	rs.mu.Lock()
+	delete(rs.clients, clientID)
+	rs.mu.Unlock()
+}
+
+// clearClientRoom removes clientID from room and marks it as
+// subscribed to no room, while leaving its stream registered in
+// clientStreams so a later JOIN_ROOM (via moveClient) can still find
+// it. This is what LEAVE_ROOM uses: without it, clientRooms[clientID]
+// would keep pointing at the room the client just left, and the next
+// moveClient would look for its stream there, not find it (already
+// removed), and fail permanently until the stream itself was torn down
+// and reopened.
+func (s *chatServer) clearClientRoom(clientID, room string) {
+	s.removeClientFromRoom(clientID, room)
+
+	s.roomsMu.Lock()
+	if s.clientRooms[clientID] == room {
+		s.clientRooms[clientID] = ""
+	}
+	s.roomsMu.Unlock()
+}
+
+// ListRooms reports every room that currently has at least one
+// connected client.
+func (s *chatServer) ListRooms(ctx context.Context, req *pb.ListRoomsRequest) (*pb.ListRoomsResponse, error) {
+	s.roomsMu.Lock()
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	s.roomsMu.Unlock()
+
+	resp := &pb.ListRoomsResponse{}
+	for _, name := range names {
+		rs := s.roomFor(name)
+		rs.mu.Lock()
+		count := len(rs.clients)
+		rs.mu.Unlock()
+		if count == 0 {
+			continue
+		}
+		resp.Rooms = append(resp.Rooms, &pb.RoomInfo{Name: name, ParticipantCount: int32(count)})
+	}
+	return resp, nil
+}
+
+// ListParticipants reports the client IDs currently subscribed to a room.
+func (s *chatServer) ListParticipants(ctx context.Context, req *pb.ListParticipantsRequest) (*pb.ListParticipantsResponse, error) {
+	rs := s.roomFor(req.GetRoom())
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	users := make([]string, 0, len(rs.clients))
+	for id := range rs.clients {
+		users = append(users, id)
+	}
+	return &pb.ListParticipantsResponse{Users: users}, nil
+}
+
 func (s *chatServer) StreamMessages(req *pb.StreamRequest, stream pb.ChatService_StreamMessagesServer) error {
-	clientID := req.GetRoom()
+	clientID := req.GetUser()
+	room := req.GetRoom()
+
+	// Allowlist enforcement happens in authStreamInterceptor, before
+	// this handler ever runs, so it applies uniformly to every RPC
+	// rather than just this one.
+	streamCtx, cancelStream := context.WithCancel(stream.Context())
+	defer cancelStream()
+	s.registerCancel(clientID, cancelStream)
+	defer s.unregisterCancel(clientID)
 
-	s.mu.Lock()
-	s.clients[clientID] = stream
-	s.mu.Unlock()
+	if since := req.GetSinceLogicalTime(); since > 0 {
+		if err := s.logger.Replay(room, since, stream.Send); err != nil {
+			logEvent(eventDeliveryErr, clientID, since, fmt.Sprintf("replay failed: %v", err))
+			return err
+		}
+	}
 
-	joinTime := s.clock.Increment()
+	rs := s.roomFor(room)
+	rs.mu.Lock()
+	rs.clients[clientID] = stream
+	rs.mu.Unlock()
+
+	s.roomsMu.Lock()
+	s.clientRooms[clientID] = room
+	s.clientStreams[clientID] = stream
+	s.roomsMu.Unlock()
+
+	joinTime := rs.clock.Increment()
 	joinMsg := &pb.ChatMessage{
 		User:        clientID,
-		Message:     fmt.Sprintf("Participant %s joined Chit Chat at logical time %d", clientID, joinTime),
+		Room:        room,
+		Message:     fmt.Sprintf("Participant %s joined room %s at logical time %d", clientID, room, joinTime),
 		LogicalTime: joinTime,
 		Type:        pb.MessageType_JOIN,
 	}
 	s.broadcast(joinMsg)
-	logEvent(eventJoin, clientID, joinTime, "client connected")
+	logEvent(eventJoin, clientID, joinTime, fmt.Sprintf("client connected to room %s", room))
 
-	<-stream.Context().Done()
+	select {
+	case <-streamCtx.Done():
+	case <-s.ctx.Done():
+	}
 
-	s.mu.Lock()
-	delete(s.clients, clientID)
-	s.mu.Unlock()
+	s.roomsMu.Lock()
+	room = s.clientRooms[clientID] // may have changed via JOIN_ROOM/LEAVE_ROOM since connect
+	delete(s.clientRooms, clientID)
+	delete(s.clientStreams, clientID)
+	s.roomsMu.Unlock()
+
+	if room == "" {
+		// The client left its last room via LEAVE_ROOM and never
+		// joined another before disconnecting; there is no room left
+		// to remove it from or announce a departure to.
+		logEvent(eventLeave, clientID, 0, "client disconnected while subscribed to no room")
+		return nil
+	}
 
-	leaveTime := s.clock.Increment()
+	s.removeClientFromRoom(clientID, room)
+
+	rs = s.roomFor(room)
+	leaveTime := rs.clock.Increment()
 	leaveMsg := &pb.ChatMessage{
 		User:        clientID,
-		Message:     fmt.Sprintf("Participant %s left Chit Chat at logical time %d", clientID, leaveTime),
+		Room:        room,
+		Message:     fmt.Sprintf("Participant %s left room %s at logical time %d", clientID, room, leaveTime),
 		LogicalTime: leaveTime,
 		Type:        pb.MessageType_LEAVE,
 	}
 	s.broadcast(leaveMsg)
-	logEvent(eventLeave, clientID, leaveTime, "client disconnected")
+	logEvent(eventLeave, clientID, leaveTime, fmt.Sprintf("client disconnected from room %s", room))
 
 	return nil
 }
 
+// broadcastShutdownNotice sends a SERVER_SHUTDOWN message to every
+// room with connected clients, so they can display it before their
+// stream is torn down.
+func (s *chatServer) broadcastShutdownNotice() {
+	s.roomsMu.Lock()
+	names := make([]string, 0, len(s.rooms))
+	for name := range s.rooms {
+		names = append(names, name)
+	}
+	s.roomsMu.Unlock()
+
+	for _, room := range names {
+		rs := s.roomFor(room)
+		t := rs.clock.Increment()
+		s.broadcast(&pb.ChatMessage{
+			Room:        room,
+			Message:     "Server is shutting down",
+			LogicalTime: t,
+			Type:        pb.MessageType_SERVER_SHUTDOWN,
+		})
+	}
+}
+
+// shutdown drives the graceful-shutdown sequence: stop accepting new
+// connections, notify connected clients, unblock their streams, and
+// fall back to an immediate stop if the graceful stop takes longer
+// than timeout. The message log is flushed after the server has
+// stopped; that flush is not bounded by timeout.
+func (s *chatServer) shutdown(grpcServer *grpc.Server, cancel context.CancelFunc, timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	s.broadcastShutdownNotice()
+	cancel() // unblocks every StreamMessages call so GracefulStop can finish
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		log.Printf("[%s] graceful stop did not finish within %s, forcing", componentServer, timeout)
+		grpcServer.Stop()
+	}
+
+	s.logger.Close()
+}
+
 func main() {
+	etcdEndpoints := flag.String("etcd-endpoints", "", "comma-separated etcd endpoints for multi-node federation; when unset the server runs single-node")
+	nodeID := flag.String("node-id", "", "identifier this node registers itself under in etcd; defaults to <hostname>-<pid>")
+	logDir := flag.String("log-dir", defaultLogDir, "directory for per-room append-only message logs")
+	adminFingerprintsFile := flag.String("admin-fingerprints", "", "file of SHA256 pubkey fingerprints granted admin privileges")
+	allowlistFile := flag.String("allowlist", "", "file of SHA256 pubkey fingerprints allowed to connect; when unset any client may connect anonymously")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for in-flight streams to drain on SIGINT/SIGTERM before forcing a stop")
+	flag.Parse()
+
 	// setup file + console logging
 	f, err := setupFileLogging("server")
 	if err != nil {
@@ -160,15 +609,64 @@ func main() {
 	}
 	defer f.Close()
 
+	var adminFingerprints, allowlist map[string]bool
+	if *adminFingerprintsFile != "" {
+		adminFingerprints, err = loadFingerprintFile(*adminFingerprintsFile)
+		if err != nil {
+			log.Fatalf("failed to load admin fingerprints: %v", err)
+		}
+	}
+	if *allowlistFile != "" {
+		allowlist, err = loadFingerprintFile(*allowlistFile)
+		if err != nil {
+			log.Fatalf("failed to load allowlist: %v", err)
+		}
+	}
+
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		log.Fatalf("listen error: %v", err)
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterChatServiceServer(grpcServer, newChatServer())
 
-	log.Printf("[%s] Server listening on %s", componentServer, addr)
-	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatalf("serve error: %v", err)
+	sigCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+
+	srv := newChatServer(serverCtx, *logDir, adminFingerprints, allowlist)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(srv.authUnaryInterceptor),
+		grpc.ChainStreamInterceptor(srv.authStreamInterceptor),
+	)
+
+	if *etcdEndpoints != "" {
+		id := *nodeID
+		if id == "" {
+			host, _ := os.Hostname()
+			id = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		if err := srv.enableFederation(serverCtx, strings.Split(*etcdEndpoints, ","), id); err != nil {
+			log.Fatalf("federation setup error: %v", err)
+		}
+	}
+
+	pb.RegisterChatServiceServer(grpcServer, srv)
+
+	serveErrCh := make(chan error, 1)
+	go func() {
+		log.Printf("[%s] Server listening on %s", componentServer, addr)
+		serveErrCh <- grpcServer.Serve(lis)
+	}()
+
+	select {
+	case err := <-serveErrCh:
+		if err != nil {
+			log.Fatalf("serve error: %v", err)
+		}
+	case <-sigCtx.Done():
+		log.Printf("[%s] shutdown signal received, draining within %s", componentServer, *shutdownTimeout)
+		srv.shutdown(grpcServer, cancelServer, *shutdownTimeout)
+		logEvent(eventShutdown, componentServer, 0, "shutdown complete")
 	}
 }
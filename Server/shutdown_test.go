@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/lordkjaer/chit-chat2.0/gRPC"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestShutdownNotifiesConnectedClients checks that every client
+// streaming from a room receives a SERVER_SHUTDOWN message with a
+// valid Lamport time before its Recv returns io.EOF.
+func TestShutdownNotifiesConnectedClients(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	srv := newChatServer(serverCtx, t.TempDir(), nil, nil)
+	pb.RegisterChatServiceServer(grpcServer, srv)
+
+	go grpcServer.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewChatServiceClient(conn)
+	stream, err := client.StreamMessages(context.Background(), &pb.StreamRequest{User: "carol", Room: "lobby"})
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv join notice: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.shutdown(grpcServer, cancelServer, 2*time.Second)
+		close(done)
+	}()
+
+	msg, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expected shutdown notice, got error: %v", err)
+	}
+	if msg.GetType() != pb.MessageType_SERVER_SHUTDOWN {
+		t.Fatalf("expected SERVER_SHUTDOWN, got %v", msg.GetType())
+	}
+	if msg.GetLogicalTime() <= 0 {
+		t.Fatalf("expected a valid lamport time on the shutdown notice, got %d", msg.GetLogicalTime())
+	}
+
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("expected io.EOF after shutdown, got %v", err)
+	}
+
+	<-done
+}